@@ -0,0 +1,118 @@
+package radix
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// DialOpts groups together the options accepted by the Dial-family
+// functions, so that TLS, AUTH, SELECT, and CLIENT SETNAME can all be
+// configured in one place instead of requiring a hand-written ConnFunc for
+// each combination.
+type DialOpts struct {
+	// Timeout, if set, is used as the dial timeout. It's also used as the
+	// read and/or write timeout on the resulting Conn, for whichever of
+	// ReadTimeout/WriteTimeout is left unset.
+	Timeout time.Duration
+
+	// ReadTimeout and WriteTimeout, if set, override Timeout for the
+	// resulting Conn's read and write deadlines respectively, so a caller
+	// can e.g. use a short dial timeout alongside a long read timeout for a
+	// blocking command connection.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// TLSConfig, if set, causes the connection to be established over TLS
+	// using this config. If TLSConfig.ServerName is empty it's derived from
+	// the host portion of the dialed address, so callers connecting to
+	// managed redis offerings (ElastiCache, Azure Cache for Redis, Upstash,
+	// ...) don't need to set it themselves.
+	TLSConfig *tls.Config
+
+	// AuthPass, if set, is used to call AUTH immediately after connecting.
+	AuthPass string
+
+	// SelectDB, if set, is used to call SELECT immediately after connecting
+	// (and after AUTH, if AuthPass is also set).
+	SelectDB string
+
+	// ClientName, if set, is used to call CLIENT SETNAME immediately after
+	// connecting.
+	ClientName string
+}
+
+// Dial creates a Conn to network/addr using these options.
+func (do DialOpts) Dial(network, addr string) (Conn, error) {
+	netConn, err := do.dialNetConn(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	readTimeout, writeTimeout := do.ReadTimeout, do.WriteTimeout
+	if readTimeout == 0 {
+		readTimeout = do.Timeout
+	}
+	if writeTimeout == 0 {
+		writeTimeout = do.Timeout
+	}
+	if readTimeout > 0 || writeTimeout > 0 {
+		netConn = &timeoutConn{Conn: netConn, readTimeout: readTimeout, writeTimeout: writeTimeout}
+	}
+	conn := NewConn(netConn)
+
+	if do.AuthPass != "" {
+		if err := conn.Do(CmdNoKey(nil, "AUTH", do.AuthPass)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if do.SelectDB != "" {
+		if err := conn.Do(CmdNoKey(nil, "SELECT", do.SelectDB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if do.ClientName != "" {
+		if err := conn.Do(CmdNoKey(nil, "CLIENT", "SETNAME", do.ClientName)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+func (do DialOpts) dialNetConn(network, addr string) (net.Conn, error) {
+	if do.TLSConfig == nil {
+		if do.Timeout > 0 {
+			return net.DialTimeout(network, addr, do.Timeout)
+		}
+		return net.Dial(network, addr)
+	}
+
+	cfg := do.TLSConfig
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			cfg.ServerName = host
+		} else {
+			cfg.ServerName = addr
+		}
+	}
+
+	if do.Timeout > 0 {
+		dialer := &net.Dialer{Timeout: do.Timeout}
+		return tls.DialWithDialer(dialer, network, addr, cfg)
+	}
+	return tls.Dial(network, addr, cfg)
+}
+
+// DialTLS is a ConnFunc which connects to network/addr over TLS using cfg.
+// If cfg.ServerName is empty it's derived from the host portion of addr.
+// DialTLS applies no timeout; callers who need both TLS and a timeout should
+// use DialOpts directly, whose Dial wraps the Conn in the same timeoutConn as
+// DialTimeout whenever a timeout is configured.
+func DialTLS(network, addr string, cfg *tls.Config) (Conn, error) {
+	return DialOpts{TLSConfig: cfg}.Dial(network, addr)
+}
@@ -0,0 +1,406 @@
+package radix
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/resp"
+)
+
+// Pipelineable may optionally be implemented by an Action. If implemented,
+// a PipelinePool will call it to decide whether the Action is safe to
+// interleave with others on a shared write buffer. Actions which need
+// exclusive, synchronous use of the connection (MULTI/EXEC blocks,
+// SUBSCRIBE, blocking commands like BLPOP) should return false.
+type Pipelineable interface {
+	Pipelineable() bool
+}
+
+func pipelineable(a Action) bool {
+	p, ok := a.(Pipelineable)
+	return !ok || p.Pipelineable()
+}
+
+var errPipelinePoolClosed = errors.New("radix: pipeline pool is closed")
+
+// errNotAConnWrap is returned by NewPipelinePool when its ConnFunc returns a
+// Conn not created via NewConn. PipelinePool needs buffered access to the
+// connection beyond what the Conn interface exposes, so it can only work
+// with this package's own connWrap implementation.
+var errNotAConnWrap = errors.New("radix: PipelinePool requires a ConnFunc which returns a Conn created via NewConn")
+
+// errPipelineDecodeWithoutEncode is returned by a pipelineTurn's Decode when
+// it's called more times than the Action called Encode, since there's no
+// way to know which reply it's meant to correspond to.
+var errPipelineDecodeWithoutEncode = errors.New("radix: Decode called on a pipelined turn with no matching Encode")
+
+// PipelinePoolOpt is an option which can be passed in to NewPipelinePool to
+// change its behavior from the default.
+type PipelinePoolOpt func(*PipelinePool)
+
+// PipelineFlushInterval changes the maximum amount of time an Action will sit
+// in a PipelinePool's write buffer before being flushed to the network. The
+// default is 150 microseconds.
+func PipelineFlushInterval(d time.Duration) PipelinePoolOpt {
+	return func(pp *PipelinePool) { pp.flushInterval = d }
+}
+
+// PipelineFlushSize changes the number of buffered writes (Encode calls, of
+// which an Action may make more than one) which will trigger an immediate
+// flush, rather than waiting on the flush interval timer. The default is 100.
+func PipelineFlushSize(n int) PipelinePoolOpt {
+	return func(pp *PipelinePool) { pp.flushSize = n }
+}
+
+// PipelinePool is a Client which amortizes network round-trips across
+// concurrent callers. Actions passed to Do/DoContext from any number of
+// goroutines are encoded onto a shared per-connection write buffer instead
+// of being flushed one at a time, and replies are decoded off the wire as
+// they arrive. This trades a small amount of added latency per Action
+// (waiting for the buffer to flush) for much higher throughput under
+// concurrent load, since RTT is paid once per flush instead of once per
+// Action.
+//
+// Actions which can't safely be interleaved with others - see Pipelineable -
+// instead get exclusive, synchronous use of a connection for the duration of
+// their Run.
+//
+// For pipelined Actions, ctx is only observed while reading the Action's own
+// reply, and canceling it then closes the shared connection. Buffering the
+// request and waiting for earlier replies on the connection to be read don't
+// observe ctx.
+type PipelinePool struct {
+	flushInterval time.Duration
+	flushSize     int
+
+	l      sync.Mutex
+	conns  []*pipelineConn
+	next   int
+	closed bool
+}
+
+// NewPipelinePool creates a PipelinePool of size connections to
+// network/addr, using cf to create each one. If cf is nil, Dial is used. cf
+// must return a Conn created via NewConn (which Dial, DialTimeout, and
+// DialTLS all do).
+func NewPipelinePool(network, addr string, size int, cf ConnFunc, opts ...PipelinePoolOpt) (*PipelinePool, error) {
+	if cf == nil {
+		cf = Dial
+	}
+
+	pp := &PipelinePool{
+		flushInterval: 150 * time.Microsecond,
+		flushSize:     100,
+	}
+	for _, opt := range opts {
+		opt(pp)
+	}
+
+	pp.conns = make([]*pipelineConn, size)
+	for i := range pp.conns {
+		conn, err := cf(network, addr)
+		if err != nil {
+			pp.Close()
+			return nil, err
+		}
+		cw, ok := conn.(*connWrap)
+		if !ok {
+			conn.Close()
+			pp.Close()
+			return nil, errNotAConnWrap
+		}
+		pp.conns[i] = newPipelineConn(cw, pp.flushInterval, pp.flushSize)
+	}
+
+	return pp, nil
+}
+
+func (pp *PipelinePool) nextConn() (*pipelineConn, error) {
+	pp.l.Lock()
+	defer pp.l.Unlock()
+	if pp.closed {
+		return nil, errPipelinePoolClosed
+	}
+	pc := pp.conns[pp.next]
+	pp.next = (pp.next + 1) % len(pp.conns)
+	return pc, nil
+}
+
+// Do implements the Client interface.
+func (pp *PipelinePool) Do(a Action) error {
+	return pp.DoContext(context.Background(), a)
+}
+
+// DoContext implements the Client interface.
+func (pp *PipelinePool) DoContext(ctx context.Context, a Action) error {
+	pc, err := pp.nextConn()
+	if err != nil {
+		return err
+	}
+
+	if !pipelineable(a) {
+		return pc.doSync(ctx, a)
+	}
+	return pc.doPipelined(ctx, a)
+}
+
+// Close implements the Client interface.
+func (pp *PipelinePool) Close() error {
+	pp.l.Lock()
+	defer pp.l.Unlock()
+	if pp.closed {
+		return nil
+	}
+	pp.closed = true
+
+	var firstErr error
+	for _, pc := range pp.conns {
+		if pc == nil {
+			continue
+		}
+		if err := pc.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// pipelineConn manages implicit pipelining over a single underlying
+// connWrap. Every write - marshaling onto cw's shared bufio.Writer,
+// assigning that write its place in the reply order, and bumping the
+// pending count - happens under writeL, since bufio.Writer isn't safe for
+// concurrent use and flush() touches the same buffer. The Encode call which
+// brings the queue up to flushSize writes flushes immediately; otherwise a
+// background flusher goroutine flushes once flushInterval has passed since
+// the first unflushed write. Reads are serialized via a
+// ticket, assigned once per Encode call (not once per turn, since a single
+// Action may Encode/Decode more than once), so that replies are always
+// decoded in the order their requests were written even though the
+// original callers are blocked in Decode concurrently.
+type pipelineConn struct {
+	cw *connWrap
+
+	flushInterval time.Duration
+	flushSize     int
+
+	// syncL is held for the duration of a synchronous (non-pipelined)
+	// Action, and is also read-locked around every pipelined turn so a
+	// synchronous Action never interleaves with in-flight pipelined writes
+	// or reads.
+	syncL sync.RWMutex
+
+	// writeL guards every write to cw's buffered writer - both the
+	// MarshalRESP call and the eventual Flush - plus the pending count and
+	// the assignment of the next read ticket, so that ticket order always
+	// matches write order.
+	writeL        sync.Mutex
+	pending       int
+	nextTicket    uint64
+	flushSignalCh chan struct{}
+
+	turnL    sync.Mutex
+	turnCond *sync.Cond
+	curTurn  uint64
+
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipelineConn(cw *connWrap, flushInterval time.Duration, flushSize int) *pipelineConn {
+	pc := &pipelineConn{
+		cw:            cw,
+		flushInterval: flushInterval,
+		flushSize:     flushSize,
+		flushSignalCh: make(chan struct{}, 1),
+		closeCh:       make(chan struct{}),
+	}
+	pc.turnCond = sync.NewCond(&pc.turnL)
+	go pc.flusher()
+	return pc
+}
+
+// doSync runs a on cw with exclusive, synchronous access, for Actions which
+// can't be safely interleaved with pipelined ones.
+func (pc *pipelineConn) doSync(ctx context.Context, a Action) error {
+	pc.syncL.Lock()
+	defer pc.syncL.Unlock()
+	return a.RunContext(ctx, pc.cw)
+}
+
+// doPipelined runs a against a turn: its Encode calls buffer their writes
+// without flushing, each reserving a read ticket in write order, and its
+// Decode calls block until it is their ticket's turn to read, then read
+// directly off the shared connection.
+func (pc *pipelineConn) doPipelined(ctx context.Context, a Action) error {
+	pc.syncL.RLock()
+	defer pc.syncL.RUnlock()
+
+	t := &pipelineTurn{pc: pc}
+	err := a.RunContext(ctx, t)
+
+	if len(t.tickets) > 0 {
+		// a reserved one or more read tickets via Encode but returned
+		// without a matching Decode for each (e.g. it bailed out after an
+		// error partway through a multi-command turn). Their replies are
+		// still unread on the wire, so the connection can't be trusted for
+		// ordering anymore - close it so any other in-flight I/O on it
+		// fails, and release the stranded tickets so turns waiting behind
+		// them in awaitTurn don't block forever.
+		pc.cw.Close()
+		for _, ticket := range t.tickets {
+			pc.awaitTurn(ticket)
+			pc.finishTurn(ticket)
+		}
+	}
+
+	return err
+}
+
+// awaitTurn blocks until ticket is the next one allowed to read, i.e. every
+// earlier ticket has finished its Decode.
+func (pc *pipelineConn) awaitTurn(ticket uint64) {
+	pc.turnL.Lock()
+	for pc.curTurn != ticket {
+		pc.turnCond.Wait()
+	}
+	pc.turnL.Unlock()
+}
+
+// finishTurn lets the next ticket proceed.
+func (pc *pipelineConn) finishTurn(ticket uint64) {
+	pc.turnL.Lock()
+	pc.curTurn = ticket + 1
+	pc.turnL.Unlock()
+	pc.turnCond.Broadcast()
+}
+
+func (pc *pipelineConn) signalFlush() {
+	select {
+	case pc.flushSignalCh <- struct{}{}:
+	default:
+	}
+}
+
+// flush holds writeL for the entire Flush call, the same lock held while
+// marshaling writes onto cw.brw, so a flush can never interleave with (or
+// race) a concurrent write.
+func (pc *pipelineConn) flush() {
+	pc.writeL.Lock()
+	defer pc.writeL.Unlock()
+	if pc.pending == 0 {
+		return
+	}
+	pc.pending = 0
+	pc.cw.brw.Flush()
+}
+
+// flusher periodically flushes buffered writes so that a burst of Encode
+// calls which never reaches flushSize isn't stuck waiting forever.
+func (pc *pipelineConn) flusher() {
+	timer := time.NewTimer(pc.flushInterval)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	armed := false
+
+	for {
+		select {
+		case <-pc.flushSignalCh:
+			if !armed {
+				timer.Reset(pc.flushInterval)
+				armed = true
+			}
+		case <-timer.C:
+			armed = false
+			pc.flush()
+		case <-pc.closeCh:
+			return
+		}
+	}
+}
+
+// Close closes the underlying connWrap and stops the flusher goroutine.
+func (pc *pipelineConn) Close() error {
+	var err error
+	pc.closeOnce.Do(func() {
+		close(pc.closeCh)
+		err = pc.cw.Close()
+	})
+	return err
+}
+
+// pipelineTurn is the Conn handed to a pipelined Action's RunContext. Its
+// Encode marshals directly into the shared connWrap's buffered writer,
+// flushing only if that brings the pending count to flushSize and otherwise
+// deferring the actual flush to pipelineConn's flusher, and records the read ticket that write was assigned; its Decode pops the
+// oldest unconsumed ticket and waits for its turn before reading the reply
+// off the shared connection, to preserve request/reply ordering. tickets is
+// only ever touched by the single goroutine running the Action, so it needs
+// no locking of its own.
+type pipelineTurn struct {
+	pc      *pipelineConn
+	tickets []uint64
+}
+
+func (t *pipelineTurn) Do(a Action) error { return t.DoContext(context.Background(), a) }
+
+func (t *pipelineTurn) DoContext(ctx context.Context, a Action) error {
+	return a.RunContext(ctx, t)
+}
+
+func (t *pipelineTurn) Close() error { return t.pc.Close() }
+
+func (t *pipelineTurn) Encode(m resp.Marshaler) error {
+	return t.EncodeContext(context.Background(), m)
+}
+
+func (t *pipelineTurn) EncodeContext(ctx context.Context, m resp.Marshaler) error {
+	pc := t.pc
+
+	pc.writeL.Lock()
+	err := m.MarshalRESP(pc.cw.brw)
+	if err != nil {
+		pc.writeL.Unlock()
+		if _, ok := err.(net.Error); ok {
+			pc.cw.Close()
+		}
+		return err
+	}
+	ticket := pc.nextTicket
+	pc.nextTicket++
+	pc.pending++
+	shouldFlush := pc.pending >= pc.flushSize
+	pc.writeL.Unlock()
+
+	t.tickets = append(t.tickets, ticket)
+
+	if shouldFlush {
+		pc.flush()
+	} else {
+		pc.signalFlush()
+	}
+	return nil
+}
+
+func (t *pipelineTurn) Decode(u resp.Unmarshaler) error {
+	return t.DecodeContext(context.Background(), u)
+}
+
+func (t *pipelineTurn) DecodeContext(ctx context.Context, u resp.Unmarshaler) error {
+	if len(t.tickets) == 0 {
+		return errPipelineDecodeWithoutEncode
+	}
+	ticket := t.tickets[0]
+	t.tickets = t.tickets[1:]
+
+	t.pc.awaitTurn(ticket)
+	defer t.pc.finishTurn(ticket)
+	return t.pc.cw.DecodeContext(ctx, u)
+}
+
+func (t *pipelineTurn) NetConn() net.Conn {
+	return t.pc.cw.NetConn()
+}
@@ -0,0 +1,162 @@
+package radix
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// simulatedRTT stands in for the network round-trip a real redis instance
+// would add; on loopback that RTT is near zero, which would hide the whole
+// point of pipelining - amortizing RTT, not CPU, across concurrent callers.
+const simulatedRTT = 200 * time.Microsecond
+
+// benchPing is a Marshaler/Unmarshaler pair which writes a minimal,
+// self-delimited request and reads a minimal reply, without depending on
+// the real RESP encoding - just enough wire traffic to let the benchmarks
+// below measure round-trip amortization without a real redis instance.
+type benchPingMarshaler struct{}
+
+func (benchPingMarshaler) MarshalRESP(w io.Writer) error {
+	_, err := w.Write([]byte("PING\r\n"))
+	return err
+}
+
+type benchPingUnmarshaler struct{}
+
+func (benchPingUnmarshaler) UnmarshalRESP(r io.Reader) error {
+	buf := make([]byte, 5) // len("+OK\r\n")
+	_, err := io.ReadFull(r, buf)
+	return err
+}
+
+// benchPingAction is an Action which round-trips a single benchPing request,
+// the same shape (one Encode, one Decode) as a real PING or GET call.
+type benchPingAction struct{}
+
+func (a benchPingAction) Run(c Conn) error {
+	return a.RunContext(context.Background(), c)
+}
+
+func (a benchPingAction) RunContext(ctx context.Context, c Conn) error {
+	if err := c.EncodeContext(ctx, benchPingMarshaler{}); err != nil {
+		return err
+	}
+	return c.DecodeContext(ctx, benchPingUnmarshaler{})
+}
+
+// newMockServer starts a listener which replies to every "PING\r\n" it
+// reads with "+OK\r\n", and returns its address along with a cleanup func.
+func newMockServer(tb testing.TB) (addr string, cleanup func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				serveMockPings(conn)
+			}()
+		}
+	}()
+
+	return ln.Addr().String(), func() {
+		ln.Close()
+		wg.Wait()
+	}
+}
+
+// delayReader sleeps before every underlying Read, standing in for the wire
+// delay a real network round trip would add. Because bufio.Reader only
+// calls through to it when its buffer is empty, several pipelined requests
+// that arrive in the same read pay that delay once between them, while
+// requests sent one synchronous round trip at a time each pay it
+// separately - which is exactly the cost pipelining amortizes away.
+type delayReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (d delayReader) Read(p []byte) (int, error) {
+	time.Sleep(d.delay)
+	return d.r.Read(p)
+}
+
+func serveMockPings(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(delayReader{r: conn, delay: simulatedRTT})
+	for {
+		if _, err := r.ReadString('\n'); err != nil {
+			return
+		}
+		if _, err := conn.Write([]byte("+OK\r\n")); err != nil {
+			return
+		}
+	}
+}
+
+// BenchmarkPool_Concurrent measures a plain Pool, where every Do pays for
+// its own round trip, under concurrent callers.
+func BenchmarkPool_Concurrent(b *testing.B) {
+	addr, cleanup := newMockServer(b)
+	defer cleanup()
+
+	p, err := NewPool("tcp", addr, 8, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer p.Close()
+
+	b.SetParallelism(64)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := p.Do(benchPingAction{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkPipelinePool_Concurrent measures a PipelinePool against the same
+// mock server and concurrency, demonstrating the throughput win from
+// amortizing RTT across concurrent callers' requests on a shared flush.
+func BenchmarkPipelinePool_Concurrent(b *testing.B) {
+	addr, cleanup := newMockServer(b)
+	defer cleanup()
+
+	pp, err := NewPipelinePool("tcp", addr, 8, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer pp.Close()
+
+	// PipelinePool's win comes from overlapping many concurrent callers'
+	// writes onto one flush, which needs more in-flight goroutines than
+	// GOMAXPROCS to show up - goroutines blocked on Decode still free up
+	// the scheduler to run the next Encode, even on a single core.
+	b.SetParallelism(64)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := pp.Do(benchPingAction{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
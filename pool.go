@@ -0,0 +1,215 @@
+package radix
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errPoolClosed = errors.New("radix: pool is closed")
+
+// PoolOpt is an option which can be passed in to NewPool to change its
+// behavior from the default.
+type PoolOpt func(*Pool)
+
+// PoolIdleTimeout changes how long a pooled Conn may sit unused before the
+// pool's reaper closes and replaces it. The default is 0, meaning
+// connections are never reaped for being idle.
+func PoolIdleTimeout(d time.Duration) PoolOpt {
+	return func(p *Pool) { p.idleTimeout = d }
+}
+
+// PoolMaxConnAge changes the maximum lifetime of a pooled Conn, regardless
+// of how recently it was used; the reaper closes and replaces any Conn
+// older than this. The default is 0, meaning connections are never reaped
+// for age.
+func PoolMaxConnAge(d time.Duration) PoolOpt {
+	return func(p *Pool) { p.maxConnAge = d }
+}
+
+// PoolReapInterval changes how often the pool's reaper goroutine scans for
+// idle or aged-out connections. The default is 30 seconds. It has no effect
+// unless PoolIdleTimeout or PoolMaxConnAge is also set.
+func PoolReapInterval(d time.Duration) PoolOpt {
+	return func(p *Pool) { p.reapInterval = d }
+}
+
+// Pool is a Client backed by a fixed-size set of connections to a single
+// redis instance, established up-front by NewPool. Do/DoContext round-robin
+// Actions across the pooled connections, taking each one's lock for the
+// duration of the call so a connection is never used by two Actions at
+// once.
+type Pool struct {
+	network, addr string
+	cf            ConnFunc
+
+	idleTimeout  time.Duration
+	maxConnAge   time.Duration
+	reapInterval time.Duration
+
+	l      sync.Mutex
+	conns  []*poolConn
+	next   int
+	closed bool
+
+	closeCh chan struct{}
+}
+
+// NewPool creates a Pool of size connections to network/addr, using cf to
+// create each one. If cf is nil, Dial is used.
+func NewPool(network, addr string, size int, cf ConnFunc, opts ...PoolOpt) (*Pool, error) {
+	if cf == nil {
+		cf = Dial
+	}
+
+	p := &Pool{
+		network:      network,
+		addr:         addr,
+		cf:           cf,
+		reapInterval: 30 * time.Second,
+		closeCh:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	p.conns = make([]*poolConn, size)
+	for i := range p.conns {
+		conn, err := cf(network, addr)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.conns[i] = newPoolConn(conn)
+	}
+
+	if p.idleTimeout > 0 || p.maxConnAge > 0 {
+		go p.reap()
+	}
+
+	return p, nil
+}
+
+func (p *Pool) nextConn() (*poolConn, error) {
+	p.l.Lock()
+	defer p.l.Unlock()
+	if p.closed {
+		return nil, errPoolClosed
+	}
+	pc := p.conns[p.next]
+	p.next = (p.next + 1) % len(p.conns)
+	return pc, nil
+}
+
+// Do implements the Client interface.
+func (p *Pool) Do(a Action) error {
+	return p.DoContext(context.Background(), a)
+}
+
+// DoContext implements the Client interface.
+func (p *Pool) DoContext(ctx context.Context, a Action) error {
+	pc, err := p.nextConn()
+	if err != nil {
+		return err
+	}
+
+	pc.l.Lock()
+	defer pc.l.Unlock()
+	defer pc.touch()
+	return a.RunContext(ctx, pc.Conn)
+}
+
+// Close implements the Client interface.
+func (p *Pool) Close() error {
+	p.l.Lock()
+	defer p.l.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.closeCh)
+
+	var firstErr error
+	for _, pc := range p.conns {
+		if pc == nil {
+			continue
+		}
+		if err := pc.Conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// poolConn is a single connection within a Pool, tracking when it was
+// created and last used so the reaper can decide when to replace it.
+type poolConn struct {
+	Conn
+	l         sync.Mutex
+	createdAt time.Time
+	usedAt    int64 // unix nanoseconds, accessed atomically
+}
+
+func newPoolConn(conn Conn) *poolConn {
+	pc := &poolConn{Conn: conn, createdAt: time.Now()}
+	pc.touch()
+	return pc
+}
+
+func (pc *poolConn) touch() {
+	atomic.StoreInt64(&pc.usedAt, time.Now().UnixNano())
+}
+
+func (pc *poolConn) idleSince() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&pc.usedAt)))
+}
+
+// reap runs for the life of the Pool, periodically replacing any connection
+// which has been idle longer than idleTimeout or alive longer than
+// maxConnAge. It takes each poolConn's own lock while swapping in the
+// replacement, the same lock DoContext holds for the duration of a command,
+// so it never closes a Conn out from under an in-flight Do/DoContext call.
+func (p *Pool) reap() {
+	t := time.NewTicker(p.reapInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-p.closeCh:
+			return
+		case <-t.C:
+			p.reapOnce()
+		}
+	}
+}
+
+func (p *Pool) reapOnce() {
+	p.l.Lock()
+	conns := append([]*poolConn(nil), p.conns...)
+	p.l.Unlock()
+
+	for _, pc := range conns {
+		aged := p.maxConnAge > 0 && time.Since(pc.createdAt) > p.maxConnAge
+		idle := p.idleTimeout > 0 && pc.idleSince() > p.idleTimeout
+		if !aged && !idle {
+			continue
+		}
+
+		newConn, err := p.cf(p.network, p.addr)
+		if err != nil {
+			// leave the old connection in place; we'll try again next scan
+			continue
+		}
+
+		pc.l.Lock()
+		oldConn := pc.Conn
+		pc.Conn = newConn
+		pc.createdAt = time.Now()
+		pc.l.Unlock()
+		pc.touch()
+
+		oldConn.Close()
+	}
+}
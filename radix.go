@@ -3,9 +3,11 @@ package radix
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mediocregopher/radix.v2/resp"
@@ -15,9 +17,15 @@ import (
 // pool for a single redis instance or the cluster client.
 type Client interface {
 	// Do performs an Action, returning any error. A Client's Do method will
-	// always be thread-safe.
+	// always be thread-safe. It is equivalent to calling DoContext with
+	// context.Background().
 	Do(Action) error
 
+	// DoContext is like Do, but aborts early and returns ctx's error if ctx
+	// is canceled or its deadline is exceeded before the Action completes.
+	// A Client's DoContext method will always be thread-safe.
+	DoContext(ctx context.Context, a Action) error
+
 	// Once Close() is called all future method calls on the Client will return
 	// an error
 	Close() error
@@ -49,6 +57,14 @@ type Conn interface {
 	Encode(resp.Marshaler) error
 	Decode(resp.Unmarshaler) error
 
+	// EncodeContext and DecodeContext are like Encode and Decode, but apply
+	// ctx's deadline (if any) to the underlying net.Conn as a write or read
+	// deadline, respectively, and close the Conn if ctx is canceled before
+	// the I/O completes. Encode and Decode are equivalent to calling these
+	// with context.Background().
+	EncodeContext(ctx context.Context, m resp.Marshaler) error
+	DecodeContext(ctx context.Context, u resp.Unmarshaler) error
+
 	// Returns the underlying network connection, as-is. Read, Write, and Close
 	// should not be called on the returned Conn.
 	NetConn() net.Conn
@@ -92,6 +108,10 @@ func NewConn(conn net.Conn) Conn {
 }
 
 func (cw *connWrap) Do(a Action) error {
+	return cw.DoContext(context.Background(), a)
+}
+
+func (cw *connWrap) DoContext(ctx context.Context, a Action) error {
 	cw.doL.Lock()
 	defer cw.doL.Unlock()
 	// the action may want to call Do on the Conn (possibly more than once), but
@@ -102,10 +122,78 @@ func (cw *connWrap) Do(a Action) error {
 		Conn: cw.Conn,
 		brw:  cw.brw,
 	}
-	return a.Run(inner)
+	return a.RunContext(ctx, inner)
+}
+
+// watchContext applies ctx's deadline (if any) as a read or write deadline on
+// the underlying net.Conn, and spawns a goroutine which closes the Conn if
+// ctx is canceled before the returned stop function is called. The caller
+// must always call the returned stop function once its I/O is complete, to
+// avoid leaking the goroutine and to clear the deadline it set.
+func (cw *connWrap) watchContext(ctx context.Context, write bool) (stop func()) {
+	// timeoutConn re-applies its own deadline before every Read/Write whose
+	// timeout is non-zero, so there's nothing stale to clean up in that case;
+	// otherwise, a deadline we set here must be cleared once we're done, or
+	// it'll still be in the past for the next call on this same (pooled) Conn.
+	tc, isTimeoutConn := cw.Conn.(*timeoutConn)
+	selfResetting := isTimeoutConn &&
+		((write && tc.writeTimeout > 0) || (!write && tc.readTimeout > 0))
+
+	dl, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		if write {
+			cw.Conn.SetWriteDeadline(dl)
+		} else {
+			cw.Conn.SetReadDeadline(dl)
+		}
+	}
+
+	clearDeadline := func() {
+		if !hasDeadline || selfResetting {
+			return
+		}
+		if write {
+			cw.Conn.SetWriteDeadline(time.Time{})
+		} else {
+			cw.Conn.SetReadDeadline(time.Time{})
+		}
+	}
+
+	if ctx.Done() == nil {
+		return clearDeadline
+	}
+
+	// ioDone arbitrates the race between ctx being canceled and the I/O
+	// finishing on its own: whichever side wins the CompareAndSwap decides
+	// whether the Conn gets closed, so a cancellation that lands just after
+	// a successful Encode/Decode can't close a Conn that's about to be
+	// returned to a pool.
+	var ioDone int32
+	stopCh := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			if atomic.CompareAndSwapInt32(&ioDone, 0, 1) {
+				cw.Close()
+			}
+		case <-stopCh:
+		}
+	}()
+	return func() {
+		atomic.CompareAndSwapInt32(&ioDone, 0, 1)
+		close(stopCh)
+		clearDeadline()
+	}
 }
 
 func (cw *connWrap) Encode(m resp.Marshaler) error {
+	return cw.EncodeContext(context.Background(), m)
+}
+
+func (cw *connWrap) EncodeContext(ctx context.Context, m resp.Marshaler) error {
+	stop := cw.watchContext(ctx, true)
+	defer stop()
+
 	err := m.MarshalRESP(cw.brw)
 	defer func() {
 		if _, ok := err.(net.Error); ok {
@@ -113,18 +201,39 @@ func (cw *connWrap) Encode(m resp.Marshaler) error {
 		}
 	}()
 
-	if err != nil {
-		return err
+	if err == nil {
+		err = cw.brw.Flush()
 	}
-	err = cw.brw.Flush()
-	return err
+	return ctxOrErr(ctx, err)
 }
 
 func (cw *connWrap) Decode(u resp.Unmarshaler) error {
+	return cw.DecodeContext(context.Background(), u)
+}
+
+func (cw *connWrap) DecodeContext(ctx context.Context, u resp.Unmarshaler) error {
+	stop := cw.watchContext(ctx, false)
+	defer stop()
+
 	err := u.UnmarshalRESP(cw.brw.Reader)
 	if _, ok := err.(net.Error); ok {
 		cw.Close()
 	}
+	return ctxOrErr(ctx, err)
+}
+
+// ctxOrErr returns ctx's error in place of err whenever err is non-nil and
+// ctx has already been canceled or its deadline exceeded, since in that case
+// err is most likely just the side-effect of watchContext closing the Conn
+// out from under the in-flight I/O, and ctx.Err() is the more meaningful
+// error to hand back to the caller.
+func ctxOrErr(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
 	return err
 }
 
@@ -148,24 +257,26 @@ func Dial(network, addr string) (Conn, error) {
 	return NewConn(c), nil
 }
 
+// timeoutConn applies separate read and write deadlines to a net.Conn before
+// each Read/Write call, rather than the single combined deadline
+// SetDeadline would apply.
 type timeoutConn struct {
 	net.Conn
-	timeout time.Duration
-}
-
-func (tc *timeoutConn) setDeadline() {
-	if tc.timeout > 0 {
-		tc.Conn.SetDeadline(time.Now().Add(tc.timeout))
-	}
+	readTimeout  time.Duration
+	writeTimeout time.Duration
 }
 
 func (tc *timeoutConn) Read(b []byte) (int, error) {
-	tc.setDeadline()
+	if tc.readTimeout > 0 {
+		tc.Conn.SetReadDeadline(time.Now().Add(tc.readTimeout))
+	}
 	return tc.Conn.Read(b)
 }
 
 func (tc *timeoutConn) Write(b []byte) (int, error) {
-	tc.setDeadline()
+	if tc.writeTimeout > 0 {
+		tc.Conn.SetWriteDeadline(time.Now().Add(tc.writeTimeout))
+	}
 	return tc.Conn.Write(b)
 }
 
@@ -176,5 +287,5 @@ func DialTimeout(network, addr string, timeout time.Duration) (Conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	return NewConn(&timeoutConn{Conn: c, timeout: timeout}), nil
+	return NewConn(&timeoutConn{Conn: c, readTimeout: timeout, writeTimeout: timeout}), nil
 }
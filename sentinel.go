@@ -0,0 +1,363 @@
+package radix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/resp"
+)
+
+// sentinelRetryDelay is how long spin waits before reconnecting after
+// listen returns, whether from a lost subscription or because none of the
+// known sentinels could be reached at all, so a sentinel outage doesn't
+// turn into a busy loop.
+const sentinelRetryDelay = 500 * time.Millisecond
+
+// Sentinel is a Client which, rather than connecting directly to a redis
+// instance, connects to a set of sentinel instances and uses them to
+// discover and connect to the current master for a given master name. If the
+// master fails over to a different instance the Sentinel will transparently
+// rebuild its connection against the new master; in-flight commands at the
+// time of the failover may return an error, but subsequent calls to Do will
+// use the new master.
+type Sentinel struct {
+	clientFunc ClientFunc
+	masterName string
+	readOnly   bool
+
+	// addrs is the set of sentinel addresses passed in to NewSentinel. It's
+	// fixed at construction time; this package doesn't issue SENTINEL
+	// sentinels to discover others.
+	addrs []string
+
+	l          sync.RWMutex
+	client     Client
+	slaves     []Client
+	listenConn Conn
+
+	closeCh   chan struct{}
+	closeWG   sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// SentinelOpt is an option which can be passed in to NewSentinel to change
+// its behavior from the default.
+type SentinelOpt func(*Sentinel)
+
+// SentinelReadOnly causes the Sentinel to route any Action which implements
+// ReadOnly and returns true from it to a randomly chosen slave, discovered
+// via SENTINEL slaves, rather than always using the master - similar to the
+// existing cluster client's read-from-replica option. Actions which don't
+// implement ReadOnly, or whose ReadOnly returns false, still go to the
+// master.
+func SentinelReadOnly() SentinelOpt {
+	return func(sc *Sentinel) { sc.readOnly = true }
+}
+
+// ReadOnly may optionally be implemented by an Action to mark it as safe to
+// run against a slave instead of the master. It's only consulted by a
+// Sentinel constructed with SentinelReadOnly.
+type ReadOnly interface {
+	ReadOnly() bool
+}
+
+// NewSentinel creates a Sentinel using the given sentinel addresses to
+// discover the current master for masterName, and clientFunc to create the
+// Client which will be used against that master. clientFunc may be nil, in
+// which case DefaultClientFunc is used.
+//
+// NewSentinel will return an error if none of the given sentinel addresses
+// could be reached, or if none of the reachable sentinels know about
+// masterName.
+func NewSentinel(masterName string, sentinelAddrs []string, clientFunc ClientFunc, opts ...SentinelOpt) (*Sentinel, error) {
+	if clientFunc == nil {
+		clientFunc = DefaultClientFunc
+	}
+	sc := &Sentinel{
+		clientFunc: clientFunc,
+		masterName: masterName,
+		addrs:      sentinelAddrs,
+		closeCh:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(sc)
+	}
+
+	if err := sc.refresh(); err != nil {
+		return nil, err
+	}
+
+	sc.closeWG.Add(1)
+	go sc.spin()
+
+	return sc, nil
+}
+
+// refresh finds the current master address by querying the sentinels in
+// order until one answers, then (re)builds the Client against that address.
+func (sc *Sentinel) refresh() error {
+	addr, sentinelConn, err := sc.getMasterAddr()
+	if err != nil {
+		return err
+	}
+	defer sentinelConn.Close()
+
+	client, err := sc.clientFunc("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	sc.l.Lock()
+	prev := sc.client
+	sc.client = client
+	sc.l.Unlock()
+
+	if prev != nil {
+		prev.Close()
+	}
+
+	if sc.readOnly {
+		sc.refreshSlaves()
+	}
+	return nil
+}
+
+// refreshSlaves rebuilds the set of slave Clients used for ReadOnly Actions
+// from the sentinels' current SENTINEL slaves answer. Slaves which can't be
+// reached are skipped rather than failing the whole refresh, since a
+// Sentinel should stay usable against the master even if a slave is down.
+func (sc *Sentinel) refreshSlaves() {
+	addrs, err := sc.slaveAddrs()
+	if err != nil {
+		return
+	}
+
+	newSlaves := make([]Client, 0, len(addrs))
+	for _, addr := range addrs {
+		client, err := sc.clientFunc("tcp", addr)
+		if err != nil {
+			continue
+		}
+		newSlaves = append(newSlaves, client)
+	}
+
+	sc.l.Lock()
+	prevSlaves := sc.slaves
+	sc.slaves = newSlaves
+	sc.l.Unlock()
+
+	for _, client := range prevSlaves {
+		client.Close()
+	}
+}
+
+// getMasterAddr asks each known sentinel, in turn, for the address of the
+// current master, returning the first successful answer along with the Conn
+// used to get it, which is left open and must be closed by the caller.
+func (sc *Sentinel) getMasterAddr() (string, Conn, error) {
+	var lastErr error
+	for _, addr := range sc.addrs {
+		conn, err := Dial("tcp", addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		var parts []string
+		err = conn.Do(CmdNoKey(&parts, "SENTINEL", "get-master-addr-by-name", sc.masterName))
+		if err != nil || len(parts) != 2 {
+			conn.Close()
+			lastErr = err
+			continue
+		}
+		return parts[0] + ":" + parts[1], conn, nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("radix: no sentinels available")
+	}
+	return "", nil, lastErr
+}
+
+// slaveAddrs returns the addresses of the slaves currently known to the
+// sentinels for this Sentinel's master, as reported by SENTINEL slaves.
+func (sc *Sentinel) slaveAddrs() ([]string, error) {
+	var lastErr error
+	for _, addr := range sc.addrs {
+		conn, err := Dial("tcp", addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		var slaves []map[string]string
+		err = conn.Do(CmdNoKey(&slaves, "SENTINEL", "slaves", sc.masterName))
+		conn.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		addrs := make([]string, 0, len(slaves))
+		for _, slave := range slaves {
+			ip, port := slave["ip"], slave["port"]
+			if ip == "" || port == "" {
+				continue
+			}
+			addrs = append(addrs, ip+":"+port)
+		}
+		return addrs, nil
+	}
+	return nil, lastErr
+}
+
+// spin subscribes to +switch-master on the first sentinel it can reach and
+// rebuilds the master Client whenever a failover is announced. If the
+// subscription connection is lost it reconnects to the first of the known
+// sentinels, in the order given to NewSentinel, that it can reach.
+func (sc *Sentinel) spin() {
+	defer sc.closeWG.Done()
+	for {
+		select {
+		case <-sc.closeCh:
+			return
+		default:
+		}
+
+		// listen's error is swallowed and retried, starting again from the
+		// first reachable sentinel; a transient sentinel outage shouldn't bring down the
+		// Client. Always wait sentinelRetryDelay before retrying, whether
+		// listen failed to connect at all or its subscription was lost,
+		// so a sustained outage doesn't turn into a busy loop.
+		sc.listen()
+
+		select {
+		case <-sc.closeCh:
+			return
+		case <-time.After(sentinelRetryDelay):
+		}
+	}
+}
+
+func (sc *Sentinel) listen() error {
+	var conn Conn
+	var err error
+	var lastErr error
+	for _, addr := range sc.addrs {
+		conn, err = Dial("tcp", addr)
+		if err == nil {
+			break
+		}
+		lastErr = err
+	}
+	if conn == nil {
+		return lastErr
+	}
+	sc.l.Lock()
+	select {
+	case <-sc.closeCh:
+		// Close may already have looked for listenConn, so it won't close
+		// this conn for us; return instead of blocking in Decode.
+		sc.l.Unlock()
+		conn.Close()
+		return nil
+	default:
+	}
+	sc.listenConn = conn
+	sc.l.Unlock()
+	defer func() {
+		sc.l.Lock()
+		sc.listenConn = nil
+		sc.l.Unlock()
+		conn.Close()
+	}()
+
+	if err := conn.Encode(resp.Any{I: []string{"SUBSCRIBE", "+switch-master"}}); err != nil {
+		return err
+	}
+	var discard interface{}
+	if err := conn.Decode(resp.Any{I: &discard}); err != nil {
+		return err
+	}
+
+	for {
+		var msg []string
+		if err := conn.Decode(resp.Any{I: &msg}); err != nil {
+			return err
+		}
+		if len(msg) < 3 || msg[0] != "message" {
+			continue
+		}
+		// payload is "<master-name> <old-ip> <old-port> <new-ip> <new-port>"
+		fields := strings.Fields(msg[2])
+		if len(fields) != 5 || fields[0] != sc.masterName {
+			continue
+		}
+		if err := sc.refresh(); err != nil {
+			return fmt.Errorf("radix: failed to rebuild client after failover: %w", err)
+		}
+	}
+}
+
+// Do implements the Client interface by passing the Action to the currently
+// known master.
+func (sc *Sentinel) Do(a Action) error {
+	return sc.DoContext(context.Background(), a)
+}
+
+// DoContext implements the Client interface by passing the Action to the
+// currently known master, unless the Sentinel was constructed with
+// SentinelReadOnly and a implements ReadOnly and returns true from it, in
+// which case it's routed to a randomly chosen slave instead.
+func (sc *Sentinel) DoContext(ctx context.Context, a Action) error {
+	sc.l.RLock()
+	client := sc.client
+	slaves := sc.slaves
+	sc.l.RUnlock()
+
+	if sc.readOnly && len(slaves) > 0 {
+		if ro, ok := a.(ReadOnly); ok && ro.ReadOnly() {
+			client = slaves[rand.Intn(len(slaves))]
+		}
+	}
+	return client.DoContext(ctx, a)
+}
+
+// Close implements the Client interface.
+func (sc *Sentinel) Close() error {
+	var err error
+	sc.closeOnce.Do(func() {
+		close(sc.closeCh)
+
+		// spin is parked in listen's Decode call, which has no deadline and
+		// won't return on its own until a +switch-master message arrives -
+		// close the conn it's blocked on so it unblocks and spin can observe
+		// closeCh, instead of closeWG.Wait() below hanging forever.
+		sc.l.Lock()
+		if sc.listenConn != nil {
+			sc.listenConn.Close()
+		}
+		sc.l.Unlock()
+
+		sc.closeWG.Wait()
+
+		sc.l.Lock()
+		client := sc.client
+		slaves := sc.slaves
+		sc.l.Unlock()
+
+		if client != nil {
+			err = client.Close()
+		}
+		for _, s := range slaves {
+			if cerr := s.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}